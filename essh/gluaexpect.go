@@ -0,0 +1,303 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/kohkimakimoto/xssh/essh/luart"
+	"github.com/kr/pty"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultExpectTimeout is the fallback for expect()/expect_regex().
+const DefaultExpectTimeout = 30 * time.Second
+
+// activeRemote is the current remote task's ssh.Session stdin/stdout pair,
+// if any; set by the task executor via SetActiveRemoteSession.
+var activeRemote *remoteSession
+
+type remoteSession struct {
+	stdin  io.Writer
+	stdout io.Reader
+}
+
+// SetActiveRemoteSession registers (or, with nil, nil, clears) the current
+// remote task's ssh.Session stdin/stdout pair for essh.expect to attach to.
+func SetActiveRemoteSession(stdin io.Writer, stdout io.Reader) {
+	if stdin == nil || stdout == nil {
+		activeRemote = nil
+		return
+	}
+	activeRemote = &remoteSession{stdin: stdin, stdout: stdout}
+}
+
+// expectSession is a spawned process (local pty or remote ssh session) plus
+// the rolling buffer of its output read so far.
+type expectSession struct {
+	pty    *os.File
+	cmd    *exec.Cmd
+	stdin  io.Writer
+	stdout io.Reader
+	buf    []byte
+
+	readOnce sync.Once
+	reads    chan expectRead
+}
+
+// expectRead is one Read result, shipped over a channel so readUntil can
+// select against it and a timer even when s.stdout has no read deadline.
+type expectRead struct {
+	b   []byte
+	err error
+}
+
+// startReader launches the single goroutine that owns s.stdout for the
+// session's lifetime - readUntil and interact() both only ever consume
+// from s.reads instead of reading session.stdout directly, so a timeout
+// never abandons a goroutine mid-Read and interact() never races a second
+// Read against it. The channel is closed once the underlying Read errors,
+// so a consumer that arrives after that (a second readUntil/interact
+// call) sees closed-channel zero values rather than blocking forever.
+func (s *expectSession) startReader() {
+	s.readOnce.Do(func() {
+		s.reads = make(chan expectRead, 16)
+		go func() {
+			defer close(s.reads)
+			for {
+				b := make([]byte, 4096)
+				n, err := s.stdout.Read(b)
+				s.reads <- expectRead{b[:n], err}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	})
+}
+
+func (s *expectSession) readUntil(re *regexp.Regexp, timeout time.Duration) (string, error) {
+	s.startReader()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if loc := re.FindIndex(s.buf); loc != nil {
+			matched := string(s.buf[loc[0]:loc[1]])
+			s.buf = s.buf[loc[1]:]
+			return matched, nil
+		}
+
+		select {
+		case r, ok := <-s.reads:
+			if !ok {
+				return "", fmt.Errorf("expect: process exited before pattern %q was seen", re.String())
+			}
+			s.buf = append(s.buf, r.b...)
+			if r.err == io.EOF {
+				return "", fmt.Errorf("expect: process exited before pattern %q was seen", re.String())
+			}
+		case <-timer.C:
+			return "", fmt.Errorf("expect: timeout waiting for pattern %q", re.String())
+		}
+	}
+}
+
+func newLExpectSession(L luart.State, s *expectSession) luart.UserData {
+	ud := L.NewUserData(s)
+	L.SetMetatable(ud, L.GetTypeMetatable(LExpectSessionClass))
+	return ud
+}
+
+func checkExpectSession(L luart.State) *expectSession {
+	ud := L.CheckUserData(1)
+	if v, ok := ud.Value().(*expectSession); ok {
+		return v
+	}
+	L.ArgError(1, "expect session expected")
+	return nil
+}
+
+const LExpectSessionClass = "ExpectSession*"
+
+// GluaExpectLoader registers the "essh.expect" module.
+func GluaExpectLoader(L luart.State) int {
+	mt := L.NewTypeMetatable(LExpectSessionClass)
+	mt.RawSetString("__index", L.SetFuncs(L.NewTable(), expectSessionMethods))
+
+	mod := L.SetFuncs(L.NewTable(), map[string]luart.LoaderFunc{
+		"spawn": expectSpawn,
+	})
+	L.Push(mod)
+	return 1
+}
+
+var expectSessionMethods = map[string]luart.LoaderFunc{
+	"expect":       expectSessionExpect,
+	"expect_regex": expectSessionExpectRegex,
+	"send":         expectSessionSend,
+	"interact":     expectSessionInteract,
+	"close":        expectSessionClose,
+}
+
+// expectSpawn implements spawn(cmd) and its table form spawn{cmd, remote=true}.
+func expectSpawn(L luart.State) int {
+	command, remote := expectSpawnArg(L)
+
+	if remote {
+		if activeRemote == nil {
+			L.RaiseError("essh.expect: spawn{remote=true} requires a task currently running over an ssh session")
+		}
+
+		session := &expectSession{
+			stdin:  activeRemote.stdin,
+			stdout: activeRemote.stdout,
+		}
+		L.Push(newLExpectSession(L, session))
+		return 1
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	f, err := pty.Start(cmd)
+	if err != nil {
+		L.RaiseError("essh.expect: failed to spawn %q: %v", command, err)
+	}
+
+	session := &expectSession{
+		pty:    f,
+		cmd:    cmd,
+		stdin:  f,
+		stdout: f,
+	}
+
+	L.Push(newLExpectSession(L, session))
+	return 1
+}
+
+// expectSpawnArg pulls the command and optional `remote` flag out of spawn's args.
+func expectSpawnArg(L luart.State) (string, bool) {
+	arg := L.Get(1)
+	if tb, ok := arg.(luart.Table); ok {
+		commandStr, ok := toString(tb.RawGetInt(1))
+		if !ok {
+			L.ArgError(1, "spawn: table form requires a string command as the first item")
+		}
+		remote, _ := toBool(tb.RawGetString("remote"))
+		return commandStr, remote
+	}
+
+	return L.CheckString(1), false
+}
+
+// expectTimeout pulls the optional `timeout` out of expect{"pattern", timeout=5}.
+func expectTimeout(L luart.State, argIndex int) (string, time.Duration) {
+	arg := L.Get(argIndex)
+	if tb, ok := arg.(luart.Table); ok {
+		patternStr, ok := toString(tb.RawGetInt(1))
+		if !ok {
+			L.ArgError(argIndex, "expect: table form requires a string pattern as the first item")
+		}
+
+		timeout := DefaultExpectTimeout
+		if t := tb.RawGetString("timeout"); t != nil {
+			if n, ok := t.(float64); ok {
+				timeout = time.Duration(n * float64(time.Second))
+			}
+		}
+		return patternStr, timeout
+	}
+
+	return L.CheckString(argIndex), DefaultExpectTimeout
+}
+
+func expectSessionExpect(L luart.State) int {
+	session := checkExpectSession(L)
+	pattern, timeout := expectTimeout(L, 2)
+
+	re, err := regexp.Compile(regexp.QuoteMeta(pattern))
+	if err != nil {
+		L.RaiseError("essh.expect: invalid pattern: %v", err)
+	}
+
+	matched, err := session.readUntil(re, timeout)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	L.Push(matched)
+	return 1
+}
+
+func expectSessionExpectRegex(L luart.State) int {
+	session := checkExpectSession(L)
+	pattern, timeout := expectTimeout(L, 2)
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		L.RaiseError("essh.expect: invalid regex: %v", err)
+	}
+
+	matched, err := session.readUntil(re, timeout)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	L.Push(matched)
+	return 1
+}
+
+func expectSessionSend(L luart.State) int {
+	session := checkExpectSession(L)
+	str := L.CheckString(2)
+
+	if _, err := io.WriteString(session.stdin, str); err != nil {
+		L.RaiseError("essh.expect: send failed: %v", err)
+	}
+
+	return 0
+}
+
+// expectSessionInteract hands the session over to the current process's
+// stdin/stdout. It flushes whatever expect()/expect_regex() already
+// buffered and keeps consuming from the same s.reads channel startReader
+// owns, rather than opening a second, independent read of session.stdout -
+// which would race the reader goroutine a prior expect() call left running
+// and silently drop any buffered bytes.
+func expectSessionInteract(L luart.State) int {
+	session := checkExpectSession(L)
+	session.startReader()
+
+	if len(session.buf) > 0 {
+		os.Stdout.Write(session.buf)
+		session.buf = nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for r := range session.reads {
+			if len(r.b) > 0 {
+				os.Stdout.Write(r.b)
+			}
+		}
+		close(done)
+	}()
+	go io.Copy(session.stdin, os.Stdin)
+
+	if session.cmd != nil {
+		session.cmd.Wait()
+	}
+	<-done
+
+	return 0
+}
+
+func expectSessionClose(L luart.State) int {
+	session := checkExpectSession(L)
+	if session.pty != nil {
+		session.pty.Close()
+	}
+	return 0
+}
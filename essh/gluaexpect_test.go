@@ -0,0 +1,160 @@
+package essh
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kohkimakimoto/xssh/essh/luart"
+)
+
+// TestReadUntilSurvivesTimeout exercises the persistent-reader fix: a
+// readUntil that times out just before its pattern arrives must not lose
+// those bytes, and a following readUntil on the same session must see them
+// instead of racing a second Read against the abandoned first one.
+func TestReadUntilSurvivesTimeout(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	s := &expectSession{stdout: r}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("foo"))
+	}()
+
+	re := regexp.MustCompile("foo")
+	if _, err := s.readUntil(re, 5*time.Millisecond); err == nil {
+		t.Fatalf("readUntil() before write: want timeout error, got nil")
+	}
+
+	matched, err := s.readUntil(re, time.Second)
+	if err != nil {
+		t.Fatalf("readUntil() after write: unexpected error %v", err)
+	}
+	if matched != "foo" {
+		t.Fatalf("readUntil() = %q, want %q", matched, "foo")
+	}
+}
+
+// TestSetActiveRemoteSessionWiring drives spawn{remote=true} end to end
+// through the stdin/stdout pair a task executor registers via
+// SetActiveRemoteSession, the way a remote `sudo` prompt would.
+func TestSetActiveRemoteSessionWiring(t *testing.T) {
+	toRemoteR, toRemoteW := io.Pipe()
+	fromRemoteR, fromRemoteW := io.Pipe()
+	defer toRemoteW.Close()
+	defer fromRemoteW.Close()
+
+	go func() {
+		buf := make([]byte, 64)
+		n, err := toRemoteR.Read(buf)
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(string(buf[:n])) == "ping" {
+			fromRemoteW.Write([]byte("pong\n"))
+		}
+	}()
+
+	SetActiveRemoteSession(toRemoteW, fromRemoteR)
+	defer SetActiveRemoteSession(nil, nil)
+
+	_, L := luart.NewState()
+	defer L.Close()
+	rt := luart.Wrap(L)
+	rt.PreloadModule("essh.expect", GluaExpectLoader)
+
+	script := `
+		local expect = require("essh.expect")
+		local s = expect.spawn{"noop", remote = true}
+		s:send("ping\n")
+		return s:expect("pong")
+	`
+	if err := L.DoString(script); err != nil {
+		t.Fatalf("DoString() error = %v", err)
+	}
+
+	got := L.Get(-1)
+	if got.String() != "pong" {
+		t.Fatalf("expect(\"pong\") = %v, want %q", got, "pong")
+	}
+}
+
+// TestExpectSessionInteractContinuesAfterExpect guards the interact() fix:
+// it must flush whatever a prior expect() left buffered and keep consuming
+// from the same reader goroutine's channel, instead of opening a second,
+// independent read of session.stdout that races the one startReader already
+// left running - which would silently drop buffered bytes or miss output.
+func TestExpectSessionInteractContinuesAfterExpect(t *testing.T) {
+	toRemoteR, toRemoteW := io.Pipe()
+	fromRemoteR, fromRemoteW := io.Pipe()
+	defer toRemoteW.Close()
+
+	go io.Copy(io.Discard, toRemoteR)
+	go func() {
+		fromRemoteW.Write([]byte("foobar"))
+		fromRemoteW.Close()
+	}()
+
+	SetActiveRemoteSession(toRemoteW, fromRemoteR)
+	defer SetActiveRemoteSession(nil, nil)
+
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() error = %v", err)
+	}
+	orig := os.Stdout
+	captured := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(stdoutR)
+		captured <- string(b)
+	}()
+
+	_, L := luart.NewState()
+	defer L.Close()
+	rt := luart.Wrap(L)
+	rt.PreloadModule("essh.expect", GluaExpectLoader)
+
+	os.Stdout = stdoutW
+	script := `
+		local expect = require("essh.expect")
+		local s = expect.spawn{"noop", remote = true}
+		local got = s:expect("foo")
+		s:interact()
+		return got
+	`
+	err = L.DoString(script)
+	os.Stdout = orig
+	stdoutW.Close()
+	if err != nil {
+		t.Fatalf("DoString() error = %v", err)
+	}
+
+	if got := L.Get(-1); got.String() != "foo" {
+		t.Fatalf("expect(\"foo\") = %v, want %q", got, "foo")
+	}
+
+	output := <-captured
+	if output != "bar" {
+		t.Fatalf("interact() wrote %q to stdout, want %q (the remainder expect() left buffered)", output, "bar")
+	}
+}
+
+// TestExpectSpawnRemoteRequiresActiveSession guards against a task executor
+// forgetting to call SetActiveRemoteSession before a plugin/task tries
+// spawn{remote=true}.
+func TestExpectSpawnRemoteRequiresActiveSession(t *testing.T) {
+	SetActiveRemoteSession(nil, nil)
+
+	_, L := luart.NewState()
+	defer L.Close()
+	rt := luart.Wrap(L)
+	rt.PreloadModule("essh.expect", GluaExpectLoader)
+
+	if err := L.DoString(`require("essh.expect").spawn{"noop", remote = true}`); err == nil {
+		t.Fatal("spawn{remote=true} with no active session: want error, got nil")
+	}
+}
@@ -0,0 +1,218 @@
+package essh
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/kohkimakimoto/xssh/essh/luart"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcMachine holds the fields essh cares about for a single "machine"
+// entry of a ~/.netrc file. Other tokens (e.g. macdef) are ignored.
+type netrcMachine struct {
+	Name     string
+	Login    string
+	Password string
+	Account  string
+}
+
+// GluaNetrcLoader registers the "essh.netrc" module, so host configs can do
+// `password = netrc.machine("host.example.com").password` instead of
+// embedding credentials in `~/.essh/config.lua`.
+func GluaNetrcLoader(L luart.State) int {
+	mod := L.SetFuncs(L.NewTable(), map[string]luart.LoaderFunc{
+		"machine": netrcLMachine,
+		"save":    netrcLSave,
+	})
+	L.Push(mod)
+	return 1
+}
+
+func netrcPath() string {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".netrc"
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// parseNetrc reads and tokenizes a ~/.netrc file. It understands `machine`,
+// `login`, `password` and `account`, and keeps a `default` entry under the
+// name "default" (lookupNetrc's fallback). Any other token, `macdef`
+// included, is ignored on its own; the words of a macro body are read as
+// ordinary tokens.
+func parseNetrc(path string) (map[string]*netrcMachine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	machines := map[string]*netrcMachine{}
+	var current *netrcMachine
+
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanWords)
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			i++
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("netrc: 'machine' with no name in %s", path)
+			}
+			current = &netrcMachine{Name: tokens[i]}
+			machines[current.Name] = current
+		case "login":
+			i++
+			if current != nil && i < len(tokens) {
+				current.Login = tokens[i]
+			}
+		case "password":
+			i++
+			if current != nil && i < len(tokens) {
+				current.Password = tokens[i]
+			}
+		case "account":
+			i++
+			if current != nil && i < len(tokens) {
+				current.Account = tokens[i]
+			}
+		case "default":
+			current = &netrcMachine{Name: "default"}
+			machines["default"] = current
+		}
+	}
+
+	return machines, nil
+}
+
+// lookupNetrc is the non-Lua entry point used by registerHost to honor
+// `netrc = true` on a host definition. A missing netrc file just means no
+// credentials are available yet, not a config error - teams share
+// config.lua with `netrc = true` hosts before anyone has saved a netrc
+// locally.
+func lookupNetrc(hostname string) (*netrcMachine, error) {
+	machines, err := parseNetrc(netrcPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if m, ok := machines[hostname]; ok {
+		return m, nil
+	}
+	if m, ok := machines["default"]; ok {
+		return m, nil
+	}
+
+	return nil, nil
+}
+
+func netrcLMachine(L luart.State) int {
+	name := L.CheckString(1)
+
+	path := netrcPath()
+	if L.GetTop() >= 2 {
+		path = L.CheckString(2)
+	}
+
+	machines, err := parseNetrc(path)
+	if err != nil {
+		L.RaiseError("essh.netrc: %v", err)
+	}
+
+	m, ok := machines[name]
+	if !ok {
+		L.Push(nil)
+		return 1
+	}
+
+	tb := L.NewTable()
+	tb.RawSetString("machine", m.Name)
+	tb.RawSetString("login", m.Login)
+	tb.RawSetString("password", m.Password)
+	tb.RawSetString("account", m.Account)
+	L.Push(tb)
+	return 1
+}
+
+// netrcLSave writes (or updates) a machine entry in the netrc file,
+// creating the file with 0600 permissions if it doesn't already exist.
+func netrcLSave(L luart.State) int {
+	name := L.CheckString(1)
+	tb := L.CheckTable(2)
+
+	path := netrcPath()
+	if L.GetTop() >= 3 {
+		path = L.CheckString(3)
+	}
+
+	machines, err := parseNetrc(path)
+	if err != nil && !os.IsNotExist(err) {
+		L.RaiseError("essh.netrc: %v", err)
+	}
+	if machines == nil {
+		machines = map[string]*netrcMachine{}
+	}
+
+	m, ok := machines[name]
+	if !ok {
+		m = &netrcMachine{Name: name}
+		machines[name] = m
+	}
+	if login, ok := toString(tb.RawGetString("login")); ok {
+		m.Login = login
+	}
+	if password, ok := toString(tb.RawGetString("password")); ok {
+		m.Password = password
+	}
+	if account, ok := toString(tb.RawGetString("account")); ok {
+		m.Account = account
+	}
+
+	var sb strings.Builder
+	for _, m := range machines {
+		if m.Name == "default" {
+			sb.WriteString("default\n")
+		} else {
+			sb.WriteString(fmt.Sprintf("machine %s\n", m.Name))
+		}
+		if m.Login != "" {
+			sb.WriteString(fmt.Sprintf("  login %s\n", m.Login))
+		}
+		if m.Password != "" {
+			sb.WriteString(fmt.Sprintf("  password %s\n", m.Password))
+		}
+		if m.Account != "" {
+			sb.WriteString(fmt.Sprintf("  account %s\n", m.Account))
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(sb.String()), 0600); err != nil {
+		L.RaiseError("essh.netrc: failed to save %s: %v", path, err)
+	}
+	// os.WriteFile's mode only applies when it creates the file, so an
+	// existing ~/.netrc with broader permissions (e.g. a default-umask
+	// editor touch) would otherwise leave the password we just wrote
+	// world/group readable.
+	if err := os.Chmod(path, 0600); err != nil {
+		L.RaiseError("essh.netrc: failed to chmod %s: %v", path, err)
+	}
+
+	return 0
+}
@@ -0,0 +1,159 @@
+package essh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kohkimakimoto/xssh/essh/luart"
+)
+
+func writeNetrcFixture(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestParseNetrcBasic(t *testing.T) {
+	path := writeNetrcFixture(t, `
+machine example.com
+  login alice
+  password s3cret
+  account billing
+
+machine other.com
+  login bob
+  password hunter2
+`)
+
+	machines, err := parseNetrc(path)
+	if err != nil {
+		t.Fatalf("parseNetrc() error = %v", err)
+	}
+
+	m, ok := machines["example.com"]
+	if !ok {
+		t.Fatalf("parseNetrc() missing machine %q", "example.com")
+	}
+	if m.Login != "alice" || m.Password != "s3cret" || m.Account != "billing" {
+		t.Fatalf("machine %q = %+v, want login=alice password=s3cret account=billing", "example.com", m)
+	}
+
+	if _, ok := machines["other.com"]; !ok {
+		t.Fatalf("parseNetrc() missing machine %q", "other.com")
+	}
+}
+
+// TestParseNetrcNoTrailingNewline guards the tokenizer against a netrc
+// file whose last line isn't newline-terminated (e.g. hand-edited).
+func TestParseNetrcNoTrailingNewline(t *testing.T) {
+	path := writeNetrcFixture(t, "machine example.com\n  login alice\n  password s3cret")
+
+	machines, err := parseNetrc(path)
+	if err != nil {
+		t.Fatalf("parseNetrc() error = %v", err)
+	}
+
+	m, ok := machines["example.com"]
+	if !ok || m.Login != "alice" || m.Password != "s3cret" {
+		t.Fatalf("parseNetrc() = %+v, want login=alice password=s3cret", machines)
+	}
+}
+
+func TestParseNetrcDefaultFallback(t *testing.T) {
+	path := writeNetrcFixture(t, `
+default
+  login anon
+  password anon
+`)
+
+	machines, err := parseNetrc(path)
+	if err != nil {
+		t.Fatalf("parseNetrc() error = %v", err)
+	}
+	d, ok := machines["default"]
+	if !ok || d.Login != "anon" {
+		t.Fatalf("parseNetrc() default entry = %+v, want login=anon", d)
+	}
+
+	t.Setenv("NETRC", path)
+	m, err := lookupNetrc("unlisted.example.com")
+	if err != nil {
+		t.Fatalf("lookupNetrc() error = %v", err)
+	}
+	if m == nil || m.Login != "anon" {
+		t.Fatalf("lookupNetrc() for unlisted host = %+v, want the default entry", m)
+	}
+}
+
+func TestParseNetrcMissingMachineName(t *testing.T) {
+	path := writeNetrcFixture(t, "machine")
+
+	if _, err := parseNetrc(path); err == nil {
+		t.Fatal("parseNetrc() with dangling 'machine' token: want error, got nil")
+	}
+}
+
+// TestNetrcLSaveDefaultEntryRoundTrips guards against netrcLSave writing a
+// "default" entry as `machine default`, which parseNetrc would read back
+// as a machine named "default" rather than the fallback entry - silently
+// breaking every `netrc = true` host that falls through to it.
+func TestNetrcLSaveDefaultEntryRoundTrips(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+
+	_, L := luart.NewState()
+	defer L.Close()
+	rt := luart.Wrap(L)
+	rt.PreloadModule("essh.netrc", GluaNetrcLoader)
+
+	script := fmt.Sprintf(`
+		local netrc = require("essh.netrc")
+		netrc.save("default", {login = "anon", password = "anon"}, %q)
+	`, path)
+	if err := L.DoString(script); err != nil {
+		t.Fatalf("DoString() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if strings.Contains(string(data), "machine default") {
+		t.Fatalf("saved netrc = %q, must not contain %q", data, "machine default")
+	}
+
+	machines, err := parseNetrc(path)
+	if err != nil {
+		t.Fatalf("parseNetrc() error = %v", err)
+	}
+	d, ok := machines["default"]
+	if !ok || d.Login != "anon" || d.Password != "anon" {
+		t.Fatalf("parseNetrc() after save = %+v, want the saved default entry", d)
+	}
+
+	t.Setenv("NETRC", path)
+	m, err := lookupNetrc("unlisted.example.com")
+	if err != nil {
+		t.Fatalf("lookupNetrc() error = %v", err)
+	}
+	if m == nil || m.Login != "anon" {
+		t.Fatalf("lookupNetrc() for unlisted host = %+v, want the saved default entry", m)
+	}
+}
+
+func TestLookupNetrcMissingFile(t *testing.T) {
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "does-not-exist"))
+
+	m, err := lookupNetrc("example.com")
+	if err != nil {
+		t.Fatalf("lookupNetrc() with no file: error = %v, want nil", err)
+	}
+	if m != nil {
+		t.Fatalf("lookupNetrc() with no file = %+v, want nil", m)
+	}
+}
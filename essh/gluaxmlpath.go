@@ -0,0 +1,131 @@
+package essh
+
+import (
+	"bytes"
+	"github.com/kohkimakimoto/xssh/essh/luart"
+	"gopkg.in/xmlpath.v2"
+)
+
+const LXmlpathPathClass = "XmlpathPath*"
+const LXmlpathNodeClass = "XmlpathNode*"
+
+// GluaXmlpathLoader registers the "essh.xmlpath" module, an XML/HTML
+// counterpart to essh.yaml/essh.json.
+func GluaXmlpathLoader(L luart.State) int {
+	pathMt := L.NewTypeMetatable(LXmlpathPathClass)
+	pathMt.RawSetString("__index", L.SetFuncs(L.NewTable(), xmlpathPathMethods))
+
+	nodeMt := L.NewTypeMetatable(LXmlpathNodeClass)
+	nodeMt.RawSetString("__index", L.SetFuncs(L.NewTable(), xmlpathNodeMethods))
+
+	mod := L.SetFuncs(L.NewTable(), map[string]luart.LoaderFunc{
+		"compile": xmlpathCompile,
+		"parse":   xmlpathParse,
+		"select":  xmlpathSelect,
+		"iter":    xmlpathIter,
+		"string":  xmlpathString,
+	})
+	L.Push(mod)
+	return 1
+}
+
+var xmlpathPathMethods = map[string]luart.LoaderFunc{}
+var xmlpathNodeMethods = map[string]luart.LoaderFunc{}
+
+func newLXmlpathPath(L luart.State, p *xmlpath.Path) luart.UserData {
+	ud := L.NewUserData(p)
+	L.SetMetatable(ud, L.GetTypeMetatable(LXmlpathPathClass))
+	return ud
+}
+
+func newLXmlpathNode(L luart.State, n *xmlpath.Node) luart.UserData {
+	ud := L.NewUserData(n)
+	L.SetMetatable(ud, L.GetTypeMetatable(LXmlpathNodeClass))
+	return ud
+}
+
+func checkXmlpathNode(L luart.State, n int) *xmlpath.Node {
+	ud := L.CheckUserData(n)
+	if v, ok := ud.Value().(*xmlpath.Node); ok {
+		return v
+	}
+	L.ArgError(n, "xmlpath node expected")
+	return nil
+}
+
+// xmlpathCompile compiles an xmlpath expression so it can be reused across
+// many select()/iter() calls without recompiling each time.
+func xmlpathCompile(L luart.State) int {
+	expr := L.CheckString(1)
+
+	p, err := xmlpath.Compile(expr)
+	if err != nil {
+		L.RaiseError("essh.xmlpath: invalid expression %q: %v", expr, err)
+	}
+
+	L.Push(newLXmlpathPath(L, p))
+	return 1
+}
+
+// xmlpathParse parses an XML/HTML document string into a root node.
+func xmlpathParse(L luart.State) int {
+	content := L.CheckString(1)
+
+	root, err := xmlpath.Parse(bytes.NewBufferString(content))
+	if err != nil {
+		L.RaiseError("essh.xmlpath: failed to parse document: %v", err)
+	}
+
+	L.Push(newLXmlpathNode(L, root))
+	return 1
+}
+
+// pathArg accepts either a pre-compiled path (from compile()) or a raw
+// expression string, compiling it on the fly in the latter case.
+func pathArg(L luart.State, n int) *xmlpath.Path {
+	if ud, ok := L.Get(n).(luart.UserData); ok {
+		if p, ok := ud.Value().(*xmlpath.Path); ok {
+			return p
+		}
+	}
+
+	expr := L.CheckString(n)
+	p, err := xmlpath.Compile(expr)
+	if err != nil {
+		L.RaiseError("essh.xmlpath: invalid expression %q: %v", expr, err)
+	}
+	return p
+}
+
+func xmlpathSelect(L luart.State) int {
+	node := checkXmlpathNode(L, 1)
+	p := pathArg(L, 2)
+
+	if s, ok := p.String(node); ok {
+		L.Push(s)
+		return 1
+	}
+
+	L.Push(nil)
+	return 1
+}
+
+func xmlpathIter(L luart.State) int {
+	node := checkXmlpathNode(L, 1)
+	p := pathArg(L, 2)
+
+	results := L.NewTable()
+	iter := p.Iter(node)
+	for iter.Next() {
+		results.Append(newLXmlpathNode(L, iter.Node()))
+	}
+
+	L.Push(results)
+	return 1
+}
+
+func xmlpathString(L luart.State) int {
+	node := checkXmlpathNode(L, 1)
+	L.Push(node.String())
+	return 1
+}
@@ -1,6 +1,7 @@
 package essh
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/cjoudrey/gluahttp"
 	"github.com/kohkimakimoto/gluafs"
@@ -8,37 +9,47 @@ import (
 	"github.com/kohkimakimoto/gluaquestion"
 	"github.com/kohkimakimoto/gluatemplate"
 	"github.com/kohkimakimoto/gluayaml"
+	"github.com/kohkimakimoto/xssh/essh/luart"
 	"github.com/yuin/gopher-lua"
+	"io"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 	"unicode"
 )
 
 var (
-	lessh *lua.LTable
+	lessh luart.Table
 )
 
+// InitLuaState wires up everything essh adds on top of stock Lua: the
+// Host/Task/Command DSL globals, the essh.* module preloads and the
+// TaskContext/PluginContext userdata classes. The DSL registration code
+// below (registerHost, registerTask, registerPlugin, esshRequire, ...) is
+// written against the essh/luart runtime-agnostic interfaces so a second
+// backend could be dropped in later without touching it; only the handful
+// of third-party gluaXXX modules still require gopher-lua directly, since
+// they predate this abstraction.
 func InitLuaState(L *lua.LState) {
+	rt := luart.Wrap(L)
+
 	// custom type.
-	registerTaskContextClass(L)
+	registerTaskContextClass(rt)
+	registerPluginContextClass(rt)
 
 	// global functions
-	L.SetGlobal("Host", L.NewFunction(esshHost))
-	L.SetGlobal("Task", L.NewFunction(esshTask))
+	rt.SetGlobal("Host", rt.NewFunction(esshHost))
+	rt.SetGlobal("Task", rt.NewFunction(esshTask))
+	rt.SetGlobal("Command", rt.NewFunction(esshCommand))
 
-	// modules
-	L.PreloadModule("essh.json", gluajson.Loader)
-	L.PreloadModule("essh.fs", gluafs.Loader)
-	L.PreloadModule("essh.yaml", gluayaml.Loader)
-	L.PreloadModule("essh.template", gluatemplate.Loader)
-	L.PreloadModule("essh.question", gluaquestion.Loader)
-	L.PreloadModule("essh.http", gluahttp.NewHttpModule(&http.Client{}).Loader)
+	preloadEsshModules(rt, L)
 
 	// global variables
-	lessh = L.NewTable()
-	L.SetGlobal("essh", lessh)
-	lessh.RawSetString("ssh_config", lua.LNil)
-	L.SetFuncs(lessh, map[string]lua.LGFunction{
+	lessh = rt.NewTable()
+	rt.SetGlobal("essh", lessh)
+	lessh.RawSetString("ssh_config", nil)
+	rt.SetFuncs(lessh, map[string]luart.LoaderFunc{
 		"host":    esshHost,
 		"task":    esshTask,
 		"require": esshRequire,
@@ -46,7 +57,43 @@ func InitLuaState(L *lua.LState) {
 	})
 }
 
-func esshHost(L *lua.LState) int {
+// esshModuleNames are the essh.* module preload names that
+// preloadEsshModules registers; preloadInertEsshModules preloads each of
+// them as an inert stand-in.
+var esshModuleNames = []string{
+	"essh.json", "essh.fs", "essh.yaml", "essh.template",
+	"essh.question", "essh.http", "essh.expect", "essh.netrc", "essh.xmlpath",
+}
+
+// preloadEsshModules registers the real essh.* module loaders (but not the
+// Host/Task/Command globals) on L.
+func preloadEsshModules(rt luart.State, L *lua.LState) {
+	// The third-party loaders still speak gopher-lua's lua.LGFunction
+	// directly; essh's own modules go through luart.
+	L.PreloadModule("essh.json", gluajson.Loader)
+	L.PreloadModule("essh.fs", gluafs.Loader)
+	L.PreloadModule("essh.yaml", gluayaml.Loader)
+	L.PreloadModule("essh.template", gluatemplate.Loader)
+	L.PreloadModule("essh.question", gluaquestion.Loader)
+	L.PreloadModule("essh.http", gluahttp.NewHttpModule(&http.Client{}).Loader)
+	rt.PreloadModule("essh.expect", GluaExpectLoader)
+	rt.PreloadModule("essh.netrc", GluaNetrcLoader)
+	rt.PreloadModule("essh.xmlpath", GluaXmlpathLoader)
+}
+
+// preloadInertEsshModules preloads each essh.* name as an empty table, so a
+// manifest-only read's top-level `require("essh.fs")` resolves without a
+// real HTTP client, filesystem access, or prompt.
+func preloadInertEsshModules(rt luart.State) {
+	for _, name := range esshModuleNames {
+		rt.PreloadModule(name, func(L luart.State) int {
+			L.Push(L.NewTable())
+			return 1
+		})
+	}
+}
+
+func esshHost(L luart.State) int {
 	name := L.CheckString(1)
 
 	// procedural style
@@ -58,7 +105,7 @@ func esshHost(L *lua.LState) int {
 	}
 
 	// DSL style
-	L.Push(L.NewFunction(func(L *lua.LState) int {
+	L.Push(L.NewFunction(func(L luart.State) int {
 		tb := L.CheckTable(1)
 		registerHost(L, name, tb)
 
@@ -68,7 +115,7 @@ func esshHost(L *lua.LState) int {
 	return 1
 }
 
-func esshTask(L *lua.LState) int {
+func esshTask(L luart.State) int {
 	name := L.CheckString(1)
 
 	// procedural style
@@ -80,7 +127,7 @@ func esshTask(L *lua.LState) int {
 	}
 
 	// DSL style
-	L.Push(L.NewFunction(func(L *lua.LState) int {
+	L.Push(L.NewFunction(func(L luart.State) int {
 		tb := L.CheckTable(1)
 		registerTask(L, name, tb)
 
@@ -90,18 +137,81 @@ func esshTask(L *lua.LState) int {
 	return 1
 }
 
-func esshReset(L *lua.LState) int {
+func esshReset(L luart.State) int {
 	Tasks = []*Task{}
 	Hosts = []*Host{}
+	Plugins = []*Plugin{}
 
 	return 0
 }
 
-func registerHost(L *lua.LState, name string, config *lua.LTable) {
+func esshCommand(L luart.State) int {
+	name := L.CheckString(1)
+
+	// procedural style
+	if L.GetTop() == 2 {
+		tb := L.CheckTable(2)
+		registerPlugin(L, name, tb)
+
+		return 0
+	}
+
+	// DSL style
+	L.Push(L.NewFunction(func(L luart.State) int {
+		tb := L.CheckTable(1)
+		registerPlugin(L, name, tb)
+
+		return 0
+	}))
+
+	return 1
+}
+
+func registerPlugin(L luart.State, name string, config luart.Table) {
+	p := &Plugin{
+		Name: name,
+	}
+
+	usage := config.RawGetString("usage")
+	if usageStr, ok := toString(usage); ok {
+		p.Usage = usageStr
+	}
+
+	short := config.RawGetString("short")
+	if shortStr, ok := toString(short); ok {
+		p.Short = shortStr
+	}
+
+	author := config.RawGetString("author")
+	if authorStr, ok := toString(author); ok {
+		p.Author = authorStr
+	}
+
+	version := config.RawGetString("version")
+	if versionStr, ok := toString(version); ok {
+		p.Version = versionStr
+	}
+
+	run := config.RawGetString("run")
+	if run != nil {
+		runFn, ok := toLFunction(run)
+		if !ok {
+			L.RaiseError("run have to be function.")
+		}
+
+		p.Run = func(ctx *PluginContext) error {
+			return L.Call(runFn, 0, newLPluginContext(L, ctx))
+		}
+	}
+
+	Plugins = append(Plugins, p)
+}
+
+func registerHost(L luart.State, name string, config luart.Table) {
 	newConfig := L.NewTable()
-	config.ForEach(func(k lua.LValue, v lua.LValue) {
+	config.ForEach(func(k, v luart.Value) {
 		var firstChar rune
-		for _, c := range k.String() {
+		for _, c := range fmt.Sprint(k) {
 			firstChar = c
 			break
 		}
@@ -158,8 +268,8 @@ func registerHost(L *lua.LState, name string, config *lua.LTable) {
 	}
 
 	tags := config.RawGetString("tags")
-	if tagsTb, ok := tags.(*lua.LTable); ok {
-		tagsTb.ForEach(func(_ lua.LValue, v lua.LValue) {
+	if tagsTb, ok := toLTable(tags); ok {
+		tagsTb.ForEach(func(_, v luart.Value) {
 			if vs, ok := toString(v); ok {
 				h.Tags = append(h.Tags, vs)
 			} else {
@@ -168,19 +278,35 @@ func registerHost(L *lua.LState, name string, config *lua.LTable) {
 		})
 	}
 
+	netrc := config.RawGetString("netrc")
+	if netrcBool, ok := toBool(netrc); ok && netrcBool {
+		hostname := name
+		if hostNameVal, ok := toString(newConfig.RawGetString("HostName")); ok {
+			hostname = hostNameVal
+		}
+
+		m, err := lookupNetrc(hostname)
+		if err != nil {
+			L.RaiseError("netrc lookup failed for host '%s': %v", name, err)
+		}
+		if m != nil {
+			if newConfig.RawGetString("User") == nil && m.Login != "" {
+				newConfig.RawSetString("User", m.Login)
+			}
+			if newConfig.RawGetString("Password") == nil && m.Password != "" {
+				newConfig.RawSetString("Password", m.Password)
+			}
+		}
+	}
+
 	Hosts = append(Hosts, h)
 }
 
-func registerHook(L *lua.LState, host *Host, hookPoint string, hook lua.LValue) error {
-	if hook != lua.LNil {
+func registerHook(L luart.State, host *Host, hookPoint string, hook luart.Value) error {
+	if hook != nil {
 		if hookFn, ok := toLFunction(hook); ok {
 			host.Hooks[hookPoint] = func() error {
-				err := L.CallByParam(lua.P{
-					Fn:      hookFn,
-					NRet:    0,
-					Protect: true,
-				})
-				return err
+				return L.Call(hookFn, 0)
 			}
 		} else if hookString, ok := toString(hook); ok {
 			host.Hooks[hookPoint] = hookString
@@ -191,8 +317,8 @@ func registerHook(L *lua.LState, host *Host, hookPoint string, hook lua.LValue)
 	return nil
 }
 
-func registerRemoteHook(L *lua.LState, host *Host, hookPoint string, hook lua.LValue) error {
-	if hook != lua.LNil {
+func registerRemoteHook(L luart.State, host *Host, hookPoint string, hook luart.Value) error {
+	if hook != nil {
 		if hookString, ok := toString(hook); ok {
 			host.Hooks[hookPoint] = hookString
 		} else {
@@ -203,7 +329,66 @@ func registerRemoteHook(L *lua.LState, host *Host, hookPoint string, hook lua.LV
 	return nil
 }
 
-func registerTask(L *lua.LState, name string, config *lua.LTable) {
+// parseRetryPolicy reads a task's `retry = { attempts=3, delay="2s",
+// backoff="exponential", max_delay="30s" }` table into a RetryPolicy,
+// defaulting any field the table doesn't set.
+func parseRetryPolicy(L luart.State, tb luart.Table) *RetryPolicy {
+	p := NewRetryPolicy()
+
+	if attempts, ok := tb.RawGetString("attempts").(float64); ok {
+		p.Attempts = int(attempts)
+	}
+
+	if delayStr, ok := toString(tb.RawGetString("delay")); ok {
+		d, err := time.ParseDuration(delayStr)
+		if err != nil {
+			L.RaiseError("invalid task definition: retry.delay: %v", err)
+		}
+		p.Delay = d
+	}
+
+	if backoffStr, ok := toString(tb.RawGetString("backoff")); ok {
+		if backoffStr != BackoffConstant && backoffStr != BackoffExponential {
+			L.RaiseError("invalid task definition: retry.backoff must be '%s' or '%s'.", BackoffConstant, BackoffExponential)
+		}
+		p.Backoff = backoffStr
+	}
+
+	if maxDelayStr, ok := toString(tb.RawGetString("max_delay")); ok {
+		d, err := time.ParseDuration(maxDelayStr)
+		if err != nil {
+			L.RaiseError("invalid task definition: retry.max_delay: %v", err)
+		}
+		p.MaxDelay = d
+	}
+
+	on := tb.RawGetString("on")
+	if on != nil {
+		onFn, ok := toLFunction(on)
+		if !ok {
+			L.RaiseError("invalid task definition: retry.on have to be function.")
+		}
+
+		p.On = func(taskErr *TaskError) bool {
+			errTb := L.NewTable()
+			errTb.RawSetString("message", taskErr.Error())
+			errTb.RawSetString("exit_status", float64(taskErr.ExitStatus))
+			errTb.RawSetString("stderr", taskErr.Stderr)
+
+			ret, err := callAndReturn(L, onFn, errTb)
+			if err != nil {
+				panic(err)
+			}
+
+			retB, _ := ret.(bool)
+			return retB
+		}
+	}
+
+	return p
+}
+
+func registerTask(L luart.State, name string, config luart.Table) {
 	task := NewTask()
 	task.Name = name
 
@@ -244,7 +429,7 @@ func registerTask(L *lua.LState, name string, config *lua.LTable) {
 	on := config.RawGetString("on")
 	if onStr, ok := toString(on); ok {
 		task.On = []string{onStr}
-	} else if onSlice, ok := toSlice(on); ok {
+	} else if onSlice, ok := toSlice(L, on); ok {
 		for _, target := range onSlice {
 			if targetStr, ok := target.(string); ok {
 				task.On = append(task.On, targetStr)
@@ -255,7 +440,7 @@ func registerTask(L *lua.LState, name string, config *lua.LTable) {
 	foreach := config.RawGetString("foreach")
 	if foreachStr, ok := toString(foreach); ok {
 		task.Foreach = []string{foreachStr}
-	} else if foreachSlice, ok := toSlice(foreach); ok {
+	} else if foreachSlice, ok := toSlice(L, foreach); ok {
 		for _, target := range foreachSlice {
 			if targetStr, ok := target.(string); ok {
 				task.Foreach = append(task.Foreach, targetStr)
@@ -280,44 +465,88 @@ func registerTask(L *lua.LState, name string, config *lua.LTable) {
 		task.Prefix = prefixStr
 	}
 
-	prepare := config.RawGetString("prepare")
-	if prepare != lua.LNil {
-		if prepareFn, ok := prepare.(*lua.LFunction); ok {
-			task.Prepare = func(ctx *TaskContext) error {
-				lctx := newLTaskContext(L, ctx)
-				err := L.CallByParam(lua.P{
-					Fn:      prepareFn,
-					NRet:    1,
-					Protect: true,
-				}, lctx)
-				if err != nil {
-					return err
-				}
+	retry := config.RawGetString("retry")
+	if retryBool, ok := toBool(retry); ok {
+		if retryBool {
+			task.Retry = NewRetryPolicy()
+		}
+	} else if retryTb, ok := toLTable(retry); ok {
+		task.Retry = parseRetryPolicy(L, retryTb)
+	}
 
-				ret := L.Get(-1) // returned value
-				L.Pop(1)
+	onError := config.RawGetString("on_error")
+	if onError != nil {
+		onErrorFn, ok := toLFunction(onError)
+		if !ok {
+			L.RaiseError("on_error have to be function.")
+		}
 
-				if ret == lua.LNil {
-					return nil
-				} else if retB, ok := ret.(lua.LBool); ok {
-					if retB {
-						return nil
-					} else {
-						return fmt.Errorf("returned false from the prepare function.")
-					}
+		task.OnError = func(ctx *TaskContext, taskErr *TaskError) RetryDecision {
+			lctx := newLTaskContext(L, ctx)
+
+			errTb := L.NewTable()
+			errTb.RawSetString("message", taskErr.Error())
+			errTb.RawSetString("exit_status", float64(taskErr.ExitStatus))
+			errTb.RawSetString("stderr", taskErr.Stderr)
+
+			ret, err := callAndReturn(L, onErrorFn, lctx, errTb)
+			if err != nil {
+				panic(err)
+			}
+
+			if decisionStr, ok := toString(ret); ok {
+				if decision, ok := parseRetryDecision(decisionStr); ok {
+					return decision
 				}
+			}
+
+			return RetryDecisionDefault
+		}
+	}
 
+	prepare := config.RawGetString("prepare")
+	if prepare != nil {
+		prepareFn, ok := toLFunction(prepare)
+		if !ok {
+			L.RaiseError("prepare have to be function.")
+		}
+
+		task.Prepare = func(ctx *TaskContext) error {
+			lctx := newLTaskContext(L, ctx)
+			ret, err := callAndReturn(L, prepareFn, lctx)
+			if err != nil {
+				return err
+			}
+
+			if ret == nil {
 				return nil
+			} else if retB, ok := ret.(bool); ok {
+				if retB {
+					return nil
+				}
+				return fmt.Errorf("returned false from the prepare function.")
 			}
-		} else {
-			L.RaiseError("prepare have to be function.")
+
+			return nil
 		}
 	}
 
 	Tasks = append(Tasks, task)
 }
 
-func esshRequire(L *lua.LState) int {
+// callAndReturn invokes a 1-return-value Lua function and hands back its
+// result, for the handful of callbacks (prepare, ...) whose return value
+// the Go side inspects.
+func callAndReturn(L luart.State, fn luart.Function, args ...luart.Value) (luart.Value, error) {
+	if err := L.Call(fn, 1, args...); err != nil {
+		return nil, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return ret, nil
+}
+
+func esshRequire(L luart.State) int {
 	name := L.CheckString(1)
 
 	module := LoadedModules[name]
@@ -349,119 +578,188 @@ func esshRequire(L *lua.LState) int {
 	return 1
 }
 
-// This code refers to https://github.com/yuin/gluamapper/blob/master/gluamapper.go
-func toGoValue(lv lua.LValue) interface{} {
-	switch v := lv.(type) {
-	case *lua.LNilType:
-		return nil
-	case lua.LBool:
-		return bool(v)
-	case lua.LString:
-		return string(v)
-	case lua.LNumber:
-		return float64(v)
-	case *lua.LTable:
-		maxn := v.MaxN()
-		if maxn == 0 { // table
-			ret := make(map[string]interface{})
-			v.ForEach(func(key, value lua.LValue) {
-				keystr := fmt.Sprint(toGoValue(key))
-				ret[keystr] = toGoValue(value)
-			})
-			return ret
-		} else { // array
-			ret := make([]interface{}, 0, maxn)
-			for i := 1; i <= maxn; i++ {
-				ret = append(ret, toGoValue(v.RawGetInt(i)))
-			}
-			return ret
-		}
-	default:
-		return v
-	}
+func toGoValue(L luart.State, v luart.Value) interface{} {
+	return L.ToGoValue(v)
 }
 
-func toBool(v lua.LValue) (bool, bool) {
-	if lv, ok := v.(lua.LBool); ok {
-		return bool(lv), true
-	} else {
-		return false, false
+func toBool(v luart.Value) (bool, bool) {
+	if lv, ok := v.(bool); ok {
+		return lv, true
 	}
+	return false, false
 }
 
-func toString(v lua.LValue) (string, bool) {
-	if lv, ok := v.(lua.LString); ok {
-		return string(lv), true
-	} else {
-		return "", false
+func toString(v luart.Value) (string, bool) {
+	if lv, ok := v.(string); ok {
+		return lv, true
 	}
+	return "", false
 }
 
-func toMap(v lua.LValue) (map[string]interface{}, bool) {
-	if lv, ok := toGoValue(v).(map[string]interface{}); ok {
+func toMap(L luart.State, v luart.Value) (map[string]interface{}, bool) {
+	if lv, ok := toGoValue(L, v).(map[string]interface{}); ok {
 		return lv, true
-	} else {
-		return nil, false
 	}
+	return nil, false
 }
 
-func toSlice(v lua.LValue) ([]interface{}, bool) {
-	if lv, ok := toGoValue(v).([]interface{}); ok {
+func toSlice(L luart.State, v luart.Value) ([]interface{}, bool) {
+	if lv, ok := toGoValue(L, v).([]interface{}); ok {
 		return lv, true
-	} else {
-		return nil, false
 	}
+	return nil, false
 }
 
-func toLFunction(v lua.LValue) (*lua.LFunction, bool) {
-	if lv, ok := v.(*lua.LFunction); ok {
+func toLFunction(v luart.Value) (luart.Function, bool) {
+	if lv, ok := v.(luart.Function); ok {
 		return lv, true
-	} else {
-		return nil, false
 	}
+	return nil, false
 }
 
-func toLTable(v lua.LValue) (*lua.LTable, bool) {
-	if lv, ok := v.(*lua.LTable); ok {
+func toLTable(v luart.Value) (luart.Table, bool) {
+	if lv, ok := v.(luart.Table); ok {
 		return lv, true
-	} else {
-		return nil, false
 	}
+	return nil, false
 }
 
 const LTaskContextClass = "TaskContext*"
 
-func newLTaskContext(L *lua.LState, ctx *TaskContext) *lua.LUserData {
-	ud := L.NewUserData()
-	ud.Value = ctx
+func newLTaskContext(L luart.State, ctx *TaskContext) luart.UserData {
+	ud := L.NewUserData(ctx)
 	L.SetMetatable(ud, L.GetTypeMetatable(LTaskContextClass))
 	return ud
 }
 
-func registerTaskContextClass(L *lua.LState) {
+func registerTaskContextClass(L luart.State) {
 	mt := L.NewTypeMetatable(LTaskContextClass)
-	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), taskContextMethods))
+	mt.RawSetString("__index", L.SetFuncs(L.NewTable(), taskContextMethods))
 }
 
-var taskContextMethods = map[string]lua.LGFunction{
+var taskContextMethods = map[string]luart.LoaderFunc{
 	"payload": taskContextPayload,
 }
 
-func taskContextPayload(L *lua.LState) int {
+func taskContextPayload(L luart.State) int {
 	ctx := checkTaskContext(L)
 	if L.GetTop() == 2 {
 		ctx.Payload = L.CheckString(2)
 		return 0
 	}
-	L.Push(lua.LString(ctx.Payload))
+	L.Push(ctx.Payload)
 	return 1
 }
 
-func checkTaskContext(L *lua.LState) *TaskContext {
+func checkTaskContext(L luart.State) *TaskContext {
 	ud := L.CheckUserData(1)
-	if v, ok := ud.Value.(*TaskContext); ok {
+	if v, ok := ud.Value().(*TaskContext); ok {
 		return v
 	}
 	L.ArgError(1, "TaskContext expected")
 	return nil
-}
\ No newline at end of file
+}
+
+const LPluginContextClass = "PluginContext*"
+
+func newLPluginContext(L luart.State, ctx *PluginContext) luart.UserData {
+	ud := L.NewUserData(ctx)
+	L.SetMetatable(ud, L.GetTypeMetatable(LPluginContextClass))
+	return ud
+}
+
+func registerPluginContextClass(L luart.State) {
+	mt := L.NewTypeMetatable(LPluginContextClass)
+	mt.RawSetString("__index", L.SetFuncs(L.NewTable(), pluginContextMethods))
+}
+
+var pluginContextMethods = map[string]luart.LoaderFunc{
+	"args":   pluginContextArgs,
+	"stdin":  pluginContextStdin,
+	"stdout": pluginContextStdout,
+	"hosts":  pluginContextHosts,
+	"tasks":  pluginContextTasks,
+}
+
+func pluginContextArgs(L luart.State) int {
+	ctx := checkPluginContext(L)
+
+	tb := L.NewTable()
+	for _, arg := range ctx.Args {
+		tb.Append(arg)
+	}
+	L.Push(tb)
+	return 1
+}
+
+// pluginContextStdin reads one line from the context's stdin, or nil at EOF.
+func pluginContextStdin(L luart.State) int {
+	ctx := checkPluginContext(L)
+	if ctx.stdinReader == nil {
+		ctx.stdinReader = bufio.NewReader(ctx.Stdin)
+	}
+
+	line, err := ctx.stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		L.Push(nil)
+		return 1
+	}
+	L.Push(strings.TrimRight(line, "\r\n"))
+	return 1
+}
+
+// pluginContextStdout writes a string to the context's stdout.
+func pluginContextStdout(L luart.State) int {
+	ctx := checkPluginContext(L)
+	str := L.CheckString(2)
+	io.WriteString(ctx.Stdout, str)
+	return 0
+}
+
+// pluginContextHosts and pluginContextTasks give a plugin's `run` function
+// a read-only handle onto the already-loaded Hosts/Tasks. Each host comes
+// back with its name and tags so a plugin can filter by tag (e.g. a
+// blue-green plugin picking the "green" group) - but there is no
+// invocation entry point here: actually running a task against targeted
+// hosts needs the task executor, which isn't part of this source tree, so
+// for now a plugin can only read these names/tags back and shell out or
+// re-exec essh itself to act on them.
+func pluginContextHosts(L luart.State) int {
+	checkPluginContext(L)
+
+	tb := L.NewTable()
+	for _, h := range Hosts {
+		htb := L.NewTable()
+		htb.RawSetString("name", h.Name)
+
+		tagsTb := L.NewTable()
+		for _, tag := range h.Tags {
+			tagsTb.Append(tag)
+		}
+		htb.RawSetString("tags", tagsTb)
+
+		tb.Append(htb)
+	}
+	L.Push(tb)
+	return 1
+}
+
+func pluginContextTasks(L luart.State) int {
+	checkPluginContext(L)
+
+	tb := L.NewTable()
+	for _, t := range Tasks {
+		tb.Append(t.Name)
+	}
+	L.Push(tb)
+	return 1
+}
+
+func checkPluginContext(L luart.State) *PluginContext {
+	ud := L.CheckUserData(1)
+	if v, ok := ud.Value().(*PluginContext); ok {
+		return v
+	}
+	L.ArgError(1, "PluginContext expected")
+	return nil
+}
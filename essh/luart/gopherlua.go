@@ -0,0 +1,246 @@
+package luart
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+)
+
+// NewState creates the default, gopher-lua backed runtime.
+func NewState() (State, *lua.LState) {
+	L := lua.NewState()
+	return &gState{L}, L
+}
+
+// sandboxedLibs are the standard libraries opened by NewSandboxedState:
+// enough to evaluate ordinary Lua (locals, tables, string/math helpers,
+// require/package.preload) without giving a script real access to the
+// outside world.
+var sandboxedLibs = []lua.LGFunction{
+	lua.OpenBase,
+	lua.OpenPackage,
+	lua.OpenTable,
+	lua.OpenString,
+	lua.OpenMath,
+}
+
+// NewSandboxedState creates a gopher-lua runtime with only sandboxedLibs
+// opened - notably not `os` or `io` - so DoFile-ing an untrusted script
+// (e.g. a plugin manifest read) can't shell out or write files even via
+// top-level code outside whatever the caller actually preloads. The base
+// and package libraries are open, so dofile, loadfile and require can
+// still read Lua files.
+func NewSandboxedState() (State, *lua.LState) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, open := range sandboxedLibs {
+		open(L)
+	}
+	return &gState{L}, L
+}
+
+// Wrap adapts an already-constructed *lua.LState (e.g. one a Go-facing
+// lua.LGFunction callback was invoked with) into a State, without creating
+// a new VM.
+func Wrap(L *lua.LState) State {
+	return &gState{L}
+}
+
+type gState struct {
+	L *lua.LState
+}
+
+func (s *gState) GetTop() int { return s.L.GetTop() }
+
+func (s *gState) CheckString(n int) string     { return s.L.CheckString(n) }
+func (s *gState) CheckBool(n int) bool         { return bool(s.L.CheckBool(n)) }
+func (s *gState) CheckNumber(n int) float64    { return float64(s.L.CheckNumber(n)) }
+func (s *gState) CheckTable(n int) Table       { return &gTable{s.L.CheckTable(n)} }
+func (s *gState) CheckUserData(n int) UserData { return &gUserData{s.L.CheckUserData(n)} }
+
+func (s *gState) Get(n int) Value { return fromLValue(s.L.Get(n)) }
+
+func (s *gState) Push(v Value) { s.L.Push(toLValue(v)) }
+
+func (s *gState) Pop(n int) { s.L.Pop(n) }
+
+func (s *gState) NewTable() Table { return &gTable{s.L.NewTable()} }
+
+func (s *gState) NewFunction(fn LoaderFunc) Function {
+	return &gFunction{s.L.NewFunction(func(L *lua.LState) int {
+		return fn(Wrap(L))
+	})}
+}
+
+func (s *gState) NewUserData(v interface{}) UserData {
+	ud := s.L.NewUserData()
+	ud.Value = v
+	return &gUserData{ud}
+}
+
+func (s *gState) SetGlobal(name string, v Value) { s.L.SetGlobal(name, toLValue(v)) }
+
+func (s *gState) SetFuncs(tb Table, funcs map[string]LoaderFunc) Table {
+	gtb := tb.(*gTable)
+	gfuncs := make(map[string]lua.LGFunction, len(funcs))
+	for name, fn := range funcs {
+		fn := fn
+		gfuncs[name] = func(L *lua.LState) int {
+			return fn(Wrap(L))
+		}
+	}
+	s.L.SetFuncs(gtb.tb, gfuncs)
+	return tb
+}
+
+func (s *gState) NewTypeMetatable(name string) Table {
+	return &gTable{s.L.NewTypeMetatable(name)}
+}
+
+func (s *gState) GetTypeMetatable(name string) Table {
+	return &gTable{s.L.GetTypeMetatable(name)}
+}
+
+func (s *gState) SetMetatable(ud UserData, mt Table) {
+	s.L.SetMetatable(ud.(*gUserData).ud, mt.(*gTable).tb)
+}
+
+func (s *gState) PreloadModule(name string, loader LoaderFunc) {
+	s.L.PreloadModule(name, func(L *lua.LState) int {
+		return loader(Wrap(L))
+	})
+}
+
+func (s *gState) DoFile(path string) error { return s.L.DoFile(path) }
+
+func (s *gState) RaiseError(format string, args ...interface{}) { s.L.RaiseError(format, args...) }
+
+func (s *gState) ArgError(n int, msg string) { s.L.ArgError(n, msg) }
+
+func (s *gState) Call(fn Function, nret int, args ...Value) error {
+	largs := make([]lua.LValue, len(args))
+	for i, a := range args {
+		largs[i] = toLValue(a)
+	}
+	return s.L.CallByParam(lua.P{
+		Fn:      fn.(*gFunction).fn,
+		NRet:    nret,
+		Protect: true,
+	}, largs...)
+}
+
+func (s *gState) ToGoValue(v Value) interface{} {
+	return toGoValue(toLValue(v))
+}
+
+// This code refers to https://github.com/yuin/gluamapper/blob/master/gluamapper.go
+func toGoValue(lv lua.LValue) interface{} {
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		return bool(v)
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case *lua.LTable:
+		maxn := v.MaxN()
+		if maxn == 0 { // table
+			ret := make(map[string]interface{})
+			v.ForEach(func(key, value lua.LValue) {
+				keystr := fmt.Sprint(toGoValue(key))
+				ret[keystr] = toGoValue(value)
+			})
+			return ret
+		}
+		// array
+		ret := make([]interface{}, 0, maxn)
+		for i := 1; i <= maxn; i++ {
+			ret = append(ret, toGoValue(v.RawGetInt(i)))
+		}
+		return ret
+	default:
+		return v
+	}
+}
+
+func toLValue(v Value) lua.LValue {
+	switch vv := v.(type) {
+	case nil:
+		return lua.LNil
+	case lua.LValue:
+		return vv
+	case bool:
+		return lua.LBool(vv)
+	case string:
+		return lua.LString(vv)
+	case float64:
+		return lua.LNumber(vv)
+	case int:
+		return lua.LNumber(vv)
+	case *gTable:
+		return vv.tb
+	case *gFunction:
+		return vv.fn
+	case *gUserData:
+		return vv.ud
+	default:
+		return lua.LNil
+	}
+}
+
+func fromLValue(lv lua.LValue) Value {
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		return bool(v)
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case *lua.LTable:
+		return &gTable{v}
+	case *lua.LFunction:
+		return &gFunction{v}
+	case *lua.LUserData:
+		return &gUserData{v}
+	default:
+		return v
+	}
+}
+
+type gTable struct {
+	tb *lua.LTable
+}
+
+func (t *gTable) RawGet(k Value) Value { return fromLValue(t.tb.RawGet(toLValue(k))) }
+
+func (t *gTable) RawGetString(k string) Value { return fromLValue(t.tb.RawGetString(k)) }
+
+func (t *gTable) RawGetInt(i int) Value { return fromLValue(t.tb.RawGetInt(i)) }
+
+func (t *gTable) RawSet(k, v Value) { t.tb.RawSet(toLValue(k), toLValue(v)) }
+
+func (t *gTable) RawSetString(k string, v Value) { t.tb.RawSetString(k, toLValue(v)) }
+
+func (t *gTable) Append(v Value) { t.tb.Append(toLValue(v)) }
+
+func (t *gTable) ForEach(fn func(k, v Value)) {
+	t.tb.ForEach(func(k, v lua.LValue) {
+		fn(fromLValue(k), fromLValue(v))
+	})
+}
+
+func (t *gTable) MaxN() int { return t.tb.MaxN() }
+
+type gFunction struct {
+	fn *lua.LFunction
+}
+
+func (f *gFunction) IsFunction() {}
+
+type gUserData struct {
+	ud *lua.LUserData
+}
+
+func (u *gUserData) Value() interface{} { return u.ud.Value }
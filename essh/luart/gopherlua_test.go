@@ -0,0 +1,104 @@
+package luart
+
+import (
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestStateTableRoundTrip(t *testing.T) {
+	_, L := NewState()
+	defer L.Close()
+	rt := Wrap(L)
+
+	tb := rt.NewTable()
+	tb.RawSetString("name", "xssh")
+	tb.Append("a")
+	tb.Append("b")
+
+	if got, ok := tb.RawGetString("name").(string); !ok || got != "xssh" {
+		t.Fatalf("RawGetString(%q) = %v, want %q", "name", tb.RawGetString("name"), "xssh")
+	}
+	if got, ok := tb.RawGetInt(2).(string); !ok || got != "b" {
+		t.Fatalf("RawGetInt(2) = %v, want %q", tb.RawGetInt(2), "b")
+	}
+	if tb.MaxN() != 2 {
+		t.Fatalf("MaxN() = %d, want 2", tb.MaxN())
+	}
+}
+
+func TestStateCallFunction(t *testing.T) {
+	_, L := NewState()
+	defer L.Close()
+	rt := Wrap(L)
+
+	var got Value
+	fn := rt.NewFunction(func(L State) int {
+		got = L.Get(1)
+		return 0
+	})
+
+	if err := rt.Call(fn, 0, "hello"); err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+	if got != "hello" {
+		t.Fatalf("function received %v, want %q", got, "hello")
+	}
+}
+
+func TestToGoValue(t *testing.T) {
+	_, L := NewState()
+	defer L.Close()
+	rt := Wrap(L)
+
+	tb := rt.NewTable()
+	tb.Append("x")
+	tb.Append("y")
+
+	got, ok := rt.ToGoValue(tb).([]interface{})
+	if !ok || len(got) != 2 || got[0] != "x" || got[1] != "y" {
+		t.Fatalf("ToGoValue(array table) = %#v", rt.ToGoValue(tb))
+	}
+}
+
+func TestPreloadModule(t *testing.T) {
+	_, L := NewState()
+	defer L.Close()
+	rt := Wrap(L)
+
+	rt.PreloadModule("essh.test", func(L State) int {
+		tb := L.NewTable()
+		tb.RawSetString("loaded", true)
+		L.Push(tb)
+		return 1
+	})
+
+	if err := L.DoString(`loaded_mod = require("essh.test")`); err != nil {
+		t.Fatalf("require(essh.test) error = %v", err)
+	}
+}
+
+func TestNewSandboxedStateHasNoOsOrIo(t *testing.T) {
+	_, L := NewSandboxedState()
+	defer L.Close()
+
+	if err := L.DoString(`return os`); err != nil {
+		t.Fatalf("referencing global 'os': unexpected error = %v", err)
+	}
+	if got := L.Get(-1); got != lua.LNil {
+		t.Fatalf("os = %v, want nil (os library must not be open)", got)
+	}
+	L.Pop(1)
+
+	if err := L.DoString(`return io`); err != nil {
+		t.Fatalf("referencing global 'io': unexpected error = %v", err)
+	}
+	if got := L.Get(-1); got != lua.LNil {
+		t.Fatalf("io = %v, want nil (io library must not be open)", got)
+	}
+	L.Pop(1)
+
+	if err := L.DoString(`return string.upper("ok"), table.concat({"a", "b"}, ","), math.max(1, 2)`); err != nil {
+		t.Fatalf("base/table/string/math libs: unexpected error = %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+// Package luart is a thin Lua runtime abstraction for essh, standing in
+// for direct use of github.com/yuin/gopher-lua in essh/lualib.go. Only
+// gopherlua.go implements it today.
+//
+// NOTE: an experimental golua/runtime-backed second implementation behind
+// -tags golua was attempted and then dropped (see git history) because it
+// didn't compile and its methods were inert stubs - not because the
+// cross-backend motivation went away.
+//
+// TODO(follow-up): a real second State implementation is still wanted, not
+// abandoned. Scope is reduced to the single gopher-lua backend until
+// someone picks this back up; don't read the absence of -tags golua here
+// as "not needed".
+package luart
+
+// Value is anything that can cross the Go/Lua boundary: nil, bool, string,
+// float64, a Table, a Function or a UserData.
+type Value interface{}
+
+// LoaderFunc is the package.preload-style module loader signature.
+type LoaderFunc func(State) int
+
+// State is a running Lua VM.
+type State interface {
+	GetTop() int
+
+	CheckString(n int) string
+	CheckTable(n int) Table
+	CheckUserData(n int) UserData
+	CheckBool(n int) bool
+	CheckNumber(n int) float64
+
+	Get(n int) Value
+	Push(v Value)
+	Pop(n int)
+
+	NewTable() Table
+	NewFunction(fn LoaderFunc) Function
+	NewUserData(v interface{}) UserData
+
+	SetGlobal(name string, v Value)
+	SetFuncs(tb Table, funcs map[string]LoaderFunc) Table
+
+	NewTypeMetatable(name string) Table
+	GetTypeMetatable(name string) Table
+	SetMetatable(ud UserData, mt Table)
+
+	PreloadModule(name string, loader LoaderFunc)
+	DoFile(path string) error
+
+	RaiseError(format string, args ...interface{})
+	ArgError(n int, msg string)
+
+	Call(fn Function, nret int, args ...Value) error
+
+	// ToGoValue recursively converts a Value into plain Go
+	// maps/slices/strings/bools/float64s.
+	ToGoValue(v Value) interface{}
+}
+
+// Table is a Lua table.
+type Table interface {
+	RawGet(k Value) Value
+	RawGetString(k string) Value
+	RawGetInt(i int) Value
+	RawSet(k, v Value)
+	RawSetString(k string, v Value)
+	Append(v Value)
+	ForEach(fn func(k, v Value))
+	MaxN() int // array part length, to tell an object-like table from an array-like one
+}
+
+// Function is a callable Lua function value: either one defined in Lua or
+// a Go function wrapped by NewFunction.
+type Function interface {
+	IsFunction()
+}
+
+// UserData wraps an arbitrary Go value so Lua code can hold a handle to it
+// and call methods through a metatable.
+type UserData interface {
+	Value() interface{}
+}
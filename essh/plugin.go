@@ -0,0 +1,257 @@
+package essh
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/kohkimakimoto/xssh/essh/luart"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Plugins holds every plugin registered via the `Command` DSL.
+var Plugins []*Plugin
+
+// Plugin is a `.lua` file under ~/.essh/plugins/ (or $ESSH_PLUGIN_PATH)
+// registered as a CLI subcommand via the `Command` DSL.
+type Plugin struct {
+	Name    string
+	Usage   string
+	Short   string
+	Author  string
+	Version string
+	File    string
+	Run     func(ctx *PluginContext) error
+}
+
+// PluginContext is the userdata passed to a plugin's `run` function.
+type PluginContext struct {
+	Args   []string
+	Stdin  *os.File
+	Stdout *os.File
+	Stderr *os.File
+
+	stdinReader *bufio.Reader
+}
+
+func NewPluginContext() *PluginContext {
+	return &PluginContext{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+// pluginManifest is the subset of Plugin metadata cheap to read without
+// evaluating a plugin's `run` function body.
+type pluginManifest struct {
+	Name    string `json:"name"`
+	Usage   string `json:"usage"`
+	Short   string `json:"short"`
+	Author  string `json:"author"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	ModTime int64  `json:"mod_time"`
+}
+
+var pluginManifestCache = map[string]*pluginManifest{}
+
+// PluginPaths returns the directories essh scans for plugin files.
+func PluginPaths() []string {
+	var paths []string
+
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".essh", "plugins"))
+	}
+
+	if envPath := os.Getenv("ESSH_PLUGIN_PATH"); envPath != "" {
+		paths = append(paths, strings.Split(envPath, ":")...)
+	}
+
+	return paths
+}
+
+func pluginManifestCachePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".essh_plugin_manifest_cache.json"
+	}
+	return filepath.Join(home, ".essh", "plugin_manifest_cache.json")
+}
+
+func loadPluginManifestCache() {
+	data, err := ioutil.ReadFile(pluginManifestCachePath())
+	if err != nil {
+		return
+	}
+	json.Unmarshal(data, &pluginManifestCache)
+}
+
+func savePluginManifestCache() error {
+	data, err := json.Marshal(pluginManifestCache)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pluginManifestCachePath(), data, 0644)
+}
+
+// LoadPluginManifests discovers plugin files under PluginPaths() and
+// returns their metadata (e.g. for `essh --help`, or for DispatchPlugin to
+// resolve a name to a path) without evaluating any plugin's real body.
+func LoadPluginManifests() ([]*pluginManifest, error) {
+	loadPluginManifestCache()
+
+	var manifests []*pluginManifest
+	for _, dir := range PluginPaths() {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, fi := range files {
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".lua") {
+				continue
+			}
+
+			path := filepath.Join(dir, fi.Name())
+			modTime := fi.ModTime().Unix()
+
+			if cached, ok := pluginManifestCache[path]; ok && cached.ModTime == modTime {
+				manifests = append(manifests, cached)
+				continue
+			}
+
+			m, err := readPluginManifest(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read plugin manifest %s: %v", path, err)
+			}
+			m.Path = path
+			m.ModTime = modTime
+			pluginManifestCache[path] = m
+			manifests = append(manifests, m)
+		}
+	}
+
+	savePluginManifestCache()
+
+	return manifests, nil
+}
+
+// readPluginManifest loads a plugin file in a throwaway, sandboxed Lua
+// state (luart.NewSandboxedState - no `os`/`io` libraries) whose `Command`
+// only records metadata and whose essh.* modules are inert stand-ins
+// (preloadInertEsshModules), so a top-level `require("essh.fs")`,
+// `os.execute(...)` or `io.open(...)` can't touch the network, filesystem,
+// or a prompt for real.
+func readPluginManifest(path string) (*pluginManifest, error) {
+	rt, L := luart.NewSandboxedState()
+	defer L.Close()
+
+	preloadInertEsshModules(rt)
+
+	m := &pluginManifest{}
+	rt.SetGlobal("Command", rt.NewFunction(func(L luart.State) int {
+		name := L.CheckString(1)
+		m.Name = name
+
+		capture := func(tb luart.Table) {
+			if s, ok := toString(tb.RawGetString("usage")); ok {
+				m.Usage = s
+			}
+			if s, ok := toString(tb.RawGetString("short")); ok {
+				m.Short = s
+			}
+			if s, ok := toString(tb.RawGetString("author")); ok {
+				m.Author = s
+			}
+			if s, ok := toString(tb.RawGetString("version")); ok {
+				m.Version = s
+			}
+		}
+
+		if L.GetTop() == 2 {
+			capture(L.CheckTable(2))
+			return 0
+		}
+
+		L.Push(L.NewFunction(func(L luart.State) int {
+			capture(L.CheckTable(1))
+			return 0
+		}))
+		return 1
+	}))
+
+	if err := rt.DoFile(path); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// loadPlugin fully evaluates the plugin file at path against L, registering
+// it onto Plugins via the real `Command` global and the real essh.*
+// modules. Unlike readPluginManifest, this is expected to run the plugin's
+// real top-level code - it's only ever called for the one plugin file a
+// dispatch actually matched by name.
+func loadPlugin(L *lua.LState, path string) error {
+	rt := luart.Wrap(L)
+	if err := rt.DoFile(path); err != nil {
+		return fmt.Errorf("failed to load plugin %s: %v", path, err)
+	}
+	return nil
+}
+
+// PluginByName returns the registered plugin with the given name, if any.
+func PluginByName(name string) *Plugin {
+	for _, p := range Plugins {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// DispatchPlugin looks args[0] up against the cheap plugin manifests (not
+// every plugin file's full body - see LoadPluginManifests) and, if it names
+// one, fully loads and runs just that one plugin with the rest of args.
+// The top-level CLI dispatcher should call this after its own built-in
+// flags and subcommands don't match, and can call LoadPluginManifests
+// directly to list plugins (e.g. for `essh --help`) without this overhead.
+func DispatchPlugin(L *lua.LState, args []string) (handled bool, err error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	manifests, err := LoadPluginManifests()
+	if err != nil {
+		return false, err
+	}
+
+	var path string
+	for _, m := range manifests {
+		if m.Name == args[0] {
+			path = m.Path
+			break
+		}
+	}
+	if path == "" {
+		return false, nil
+	}
+
+	if err := loadPlugin(L, path); err != nil {
+		return false, err
+	}
+
+	p := PluginByName(args[0])
+	if p == nil {
+		return false, fmt.Errorf("plugin %q at %s didn't register itself under its manifest name", args[0], path)
+	}
+
+	ctx := NewPluginContext()
+	ctx.Args = args[1:]
+
+	return true, p.Run(ctx)
+}
@@ -0,0 +1,219 @@
+package essh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// setupPluginDir isolates PluginPaths()/pluginManifestCachePath() to a
+// fresh temp directory for the duration of the test, and resets the
+// package-level caches a previous test may have populated.
+func setupPluginDir(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("ESSH_PLUGIN_PATH", "")
+
+	dir := filepath.Join(home, ".essh", "plugins")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+
+	pluginManifestCache = map[string]*pluginManifest{}
+	Plugins = nil
+
+	return dir
+}
+
+func writePlugin(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("WriteFile(%s) error = %v", path, err)
+	}
+	return path
+}
+
+func TestLoadPluginManifestsReadsMetadataWithoutRunningRun(t *testing.T) {
+	dir := setupPluginDir(t)
+	writePlugin(t, dir, "deploy.lua", `
+Command("deploy", {
+	usage = "deploy [env]",
+	short = "deploy the app",
+	author = "ops",
+	version = "1.0",
+	run = function(ctx) error("run must not be called by a manifest read") end,
+})
+`)
+
+	manifests, err := LoadPluginManifests()
+	if err != nil {
+		t.Fatalf("LoadPluginManifests() error = %v", err)
+	}
+	if len(manifests) != 1 {
+		t.Fatalf("LoadPluginManifests() returned %d manifests, want 1", len(manifests))
+	}
+
+	m := manifests[0]
+	if m.Name != "deploy" || m.Usage != "deploy [env]" || m.Short != "deploy the app" || m.Author != "ops" || m.Version != "1.0" {
+		t.Fatalf("manifest = %+v, want name/usage/short/author/version from the plugin file", m)
+	}
+}
+
+func TestLoadPluginManifestsSandboxesTopLevelCode(t *testing.T) {
+	dir := setupPluginDir(t)
+	marker := filepath.Join(dir, "marker")
+	writePlugin(t, dir, "unsafe.lua", fmt.Sprintf(`
+local f = io.open(%q, "w")
+Command("unsafe", {usage = "u", run = function(ctx) end})
+`, marker))
+
+	if _, err := LoadPluginManifests(); err == nil {
+		t.Fatal("LoadPluginManifests() with top-level io.open: want error, got nil")
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("marker file exists after a manifest-only read: os/io must not be available to it")
+	}
+}
+
+func TestLoadPluginManifestsCachesByModTime(t *testing.T) {
+	dir := setupPluginDir(t)
+	path := writePlugin(t, dir, "deploy.lua", `Command("deploy", {short = "v1", run = function(ctx) end})`)
+
+	manifests, err := LoadPluginManifests()
+	if err != nil {
+		t.Fatalf("LoadPluginManifests() error = %v", err)
+	}
+	if manifests[0].Short != "v1" {
+		t.Fatalf("manifest.Short = %q, want %q", manifests[0].Short, "v1")
+	}
+
+	// Simulate a stale cache entry without changing the file's mtime: a
+	// second read should return the cached (stale) value, proving the
+	// mtime-keyed cache hit path is actually taken. The entry is saved to
+	// the cache file too, since LoadPluginManifests reloads it from disk.
+	pluginManifestCache[path].Short = "stale-cache-hit"
+	if err := savePluginManifestCache(); err != nil {
+		t.Fatalf("savePluginManifestCache() error = %v", err)
+	}
+
+	manifests, err = LoadPluginManifests()
+	if err != nil {
+		t.Fatalf("LoadPluginManifests() error = %v", err)
+	}
+	if manifests[0].Short != "stale-cache-hit" {
+		t.Fatalf("manifest.Short = %q, want the cached %q (cache hit expected)", manifests[0].Short, "stale-cache-hit")
+	}
+
+	// Now bump the mtime forward and rewrite the file: the cache should be
+	// invalidated and the fresh content read back.
+	if err := writeAndTouch(path, `Command("deploy", {short = "v2", run = function(ctx) end})`); err != nil {
+		t.Fatalf("writeAndTouch() error = %v", err)
+	}
+
+	manifests, err = LoadPluginManifests()
+	if err != nil {
+		t.Fatalf("LoadPluginManifests() error = %v", err)
+	}
+	if manifests[0].Short != "v2" {
+		t.Fatalf("manifest.Short = %q, want %q (cache should invalidate on mtime change)", manifests[0].Short, "v2")
+	}
+}
+
+// writeAndTouch rewrites path and forces its mtime forward, since some
+// filesystems have coarser mtime resolution than a fast test can rely on.
+func writeAndTouch(path, contents string) error {
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		return err
+	}
+	future := time.Now().Add(time.Hour)
+	return os.Chtimes(path, future, future)
+}
+
+func TestDispatchPluginOnlyLoadsTheMatchedPlugin(t *testing.T) {
+	dir := setupPluginDir(t)
+	deployMarker := filepath.Join(dir, "deploy.ran")
+	otherMarker := filepath.Join(dir, "other.ran")
+
+	writePlugin(t, dir, "deploy.lua", fmt.Sprintf(`
+Command("deploy", {
+	usage = "u",
+	run = function(ctx)
+		local f = io.open(%q, "w")
+		f:write("ran")
+		f:close()
+	end,
+})
+`, deployMarker))
+
+	// other.lua's top level runs for real the instant it's DoFile'd with a
+	// real, non-sandboxed state - if DispatchPlugin("deploy") ever fully
+	// loads every plugin file (the bug this fixes), this marker appears too.
+	// The `if io` guard lets the sandboxed manifest read, which has no io
+	// library, pass over the write instead of failing.
+	writePlugin(t, dir, "other.lua", fmt.Sprintf(`
+if io then
+	local f = io.open(%q, "w")
+	f:write("ran")
+	f:close()
+end
+Command("other", {usage = "u", run = function(ctx) end})
+`, otherMarker))
+
+	L := lua.NewState()
+	defer L.Close()
+	InitLuaState(L)
+
+	handled, err := DispatchPlugin(L, []string{"deploy"})
+	if err != nil {
+		t.Fatalf("DispatchPlugin() error = %v", err)
+	}
+	if !handled {
+		t.Fatal("DispatchPlugin(\"deploy\") handled = false, want true")
+	}
+
+	if _, err := os.Stat(deployMarker); err != nil {
+		t.Fatalf("deploy's run didn't execute: %v", err)
+	}
+	if _, err := os.Stat(otherMarker); !os.IsNotExist(err) {
+		t.Fatal("other.lua's top level ran even though only \"deploy\" was dispatched")
+	}
+	if PluginByName("other") != nil {
+		t.Fatal("other.lua got registered into Plugins even though it was never dispatched")
+	}
+}
+
+func TestDispatchPluginUnknownNameLoadsNothing(t *testing.T) {
+	dir := setupPluginDir(t)
+	marker := filepath.Join(dir, "deploy.ran")
+	writePlugin(t, dir, "deploy.lua", fmt.Sprintf(`
+Command("deploy", {
+	usage = "u",
+	run = function(ctx)
+		local f = io.open(%q, "w")
+		f:close()
+	end,
+})
+`, marker))
+
+	L := lua.NewState()
+	defer L.Close()
+	InitLuaState(L)
+
+	handled, err := DispatchPlugin(L, []string{"nope"})
+	if err != nil {
+		t.Fatalf("DispatchPlugin() error = %v", err)
+	}
+	if handled {
+		t.Fatal("DispatchPlugin(\"nope\") handled = true, want false")
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatal("deploy.lua ran even though it was never the dispatched command")
+	}
+}
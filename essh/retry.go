@@ -0,0 +1,130 @@
+package essh
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy is a Task's `retry = { attempts=3, delay="2s",
+// backoff="exponential", max_delay="30s" }` table.
+type RetryPolicy struct {
+	Attempts int
+	Delay    time.Duration
+	Backoff  string
+	MaxDelay time.Duration
+	// On decides whether a failure is worth retrying at all; nil means always.
+	On func(taskErr *TaskError) bool
+}
+
+const (
+	BackoffConstant    = "constant"
+	BackoffExponential = "exponential"
+)
+
+// NewRetryPolicy is the policy for a bare `retry = true`: one retry, fixed 1s delay.
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Attempts: 1,
+		Delay:    time.Second,
+		Backoff:  BackoffConstant,
+		MaxDelay: 30 * time.Second,
+	}
+}
+
+// NextDelay returns how long to sleep before the given retry attempt
+// (1-indexed), with up to 20% jitter.
+func (p *RetryPolicy) NextDelay(attempt int) time.Duration {
+	d := p.Delay
+	if p.Backoff == BackoffExponential {
+		for i := 1; i < attempt; i++ {
+			d *= 2
+			if d >= p.MaxDelay {
+				d = p.MaxDelay
+				break
+			}
+		}
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(d)/5 + 1))
+	return d + jitter
+}
+
+// TaskError is what a failed task execution hands to a Task's `on_error` hook.
+type TaskError struct {
+	Err        error
+	ExitStatus int
+	Stderr     string
+}
+
+func (e *TaskError) Error() string {
+	return e.Err.Error()
+}
+
+// RetryDecision is how a Task's `on_error` hook can override the retry
+// policy for one particular failure.
+type RetryDecision int
+
+const (
+	RetryDecisionDefault RetryDecision = iota // defer to the task's retry policy
+	RetryDecisionRetry
+	RetryDecisionSkip
+	RetryDecisionAbort
+)
+
+func parseRetryDecision(s string) (RetryDecision, bool) {
+	switch s {
+	case "retry":
+		return RetryDecisionRetry, true
+	case "skip":
+		return RetryDecisionSkip, true
+	case "abort":
+		return RetryDecisionAbort, true
+	default:
+		return RetryDecisionDefault, false
+	}
+}
+
+// Attempt runs a task once and reports the failure, if any.
+type Attempt func() *TaskError
+
+// RunWithRetry drives the try/sleep/retry loop for one host's Attempt
+// against policy and onError; call once per host so a `parallel = true`
+// task's hosts retry independently of one another.
+func RunWithRetry(ctx *TaskContext, policy *RetryPolicy, onError func(ctx *TaskContext, taskErr *TaskError) RetryDecision, attempt Attempt) *TaskError {
+	if policy == nil {
+		policy = &RetryPolicy{Attempts: 0}
+	}
+
+	for try := 0; ; try++ {
+		taskErr := attempt()
+		if taskErr == nil {
+			return nil
+		}
+
+		decision := RetryDecisionDefault
+		if onError != nil {
+			decision = onError(ctx, taskErr)
+		}
+
+		switch decision {
+		case RetryDecisionAbort:
+			return taskErr
+		case RetryDecisionSkip:
+			return nil
+		case RetryDecisionRetry:
+			// on_error asked for another attempt regardless of Attempts/On.
+		default:
+			if policy.On != nil && !policy.On(taskErr) {
+				return taskErr
+			}
+			if try >= policy.Attempts {
+				return taskErr
+			}
+		}
+
+		time.Sleep(policy.NextDelay(try + 1))
+	}
+}
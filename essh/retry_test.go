@@ -0,0 +1,58 @@
+package essh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyNextDelayConstant(t *testing.T) {
+	p := &RetryPolicy{Delay: time.Second, Backoff: BackoffConstant, MaxDelay: 30 * time.Second}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		d := p.NextDelay(attempt)
+		if d < time.Second || d > time.Second+time.Second/5 {
+			t.Fatalf("NextDelay(%d) = %v, want in [1s, 1.2s]", attempt, d)
+		}
+	}
+}
+
+func TestRetryPolicyNextDelayExponential(t *testing.T) {
+	p := &RetryPolicy{Delay: time.Second, Backoff: BackoffExponential, MaxDelay: 30 * time.Second}
+
+	base := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for i, attempt := range []int{1, 2, 3} {
+		d := p.NextDelay(attempt)
+		want := base[i]
+		if d < want || d > want+want/5 {
+			t.Fatalf("NextDelay(%d) = %v, want in [%v, %v]", attempt, d, want, want+want/5)
+		}
+	}
+}
+
+func TestRetryPolicyNextDelayCapsAtMaxDelay(t *testing.T) {
+	p := &RetryPolicy{Delay: time.Second, Backoff: BackoffExponential, MaxDelay: 5 * time.Second}
+
+	// Uncapped exponential growth would reach 2^9s = 512s by attempt 10.
+	d := p.NextDelay(10)
+	if d < 5*time.Second || d > 5*time.Second+time.Second {
+		t.Fatalf("NextDelay(10) = %v, want capped around MaxDelay (5s-6s)", d)
+	}
+}
+
+func TestParseRetryDecision(t *testing.T) {
+	cases := map[string]RetryDecision{
+		"retry": RetryDecisionRetry,
+		"skip":  RetryDecisionSkip,
+		"abort": RetryDecisionAbort,
+	}
+	for in, want := range cases {
+		got, ok := parseRetryDecision(in)
+		if !ok || got != want {
+			t.Fatalf("parseRetryDecision(%q) = (%v, %v), want (%v, true)", in, got, ok, want)
+		}
+	}
+
+	if _, ok := parseRetryDecision("bogus"); ok {
+		t.Fatalf("parseRetryDecision(%q) ok = true, want false", "bogus")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kohkimakimoto/xssh/essh"
+	"github.com/yuin/gopher-lua"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run is essh's top-level CLI dispatcher: the built-in -h/--help flag is
+// tried first; anything else is handed to essh.DispatchPlugin so a
+// `.lua` file under ~/.essh/plugins/ (or $ESSH_PLUGIN_PATH) can serve as a
+// first-class subcommand, e.g. `essh deploy`. Tasks are not run from here.
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		return printUsage()
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	essh.InitLuaState(L)
+
+	handled, err := essh.DispatchPlugin(L, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "essh:", err)
+		return 1
+	}
+	if handled {
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "essh: no such plugin: %q\n", args[0])
+	return 1
+}
+
+// printUsage lists plugin subcommands via essh.LoadPluginManifests, which
+// only reads each plugin file's `Command{...}` metadata - it never runs a
+// plugin's `run` function or any real top-level side effect.
+func printUsage() int {
+	fmt.Fprintln(os.Stderr, "usage: essh [flags] <plugin> [args]")
+
+	manifests, err := essh.LoadPluginManifests()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "essh: failed to list plugins:", err)
+		return 1
+	}
+
+	if len(manifests) > 0 {
+		fmt.Fprintln(os.Stderr, "\nplugins:")
+		for _, m := range manifests {
+			short := m.Short
+			if short == "" {
+				short = m.Usage
+			}
+			fmt.Fprintf(os.Stderr, "  %-20s %s\n", m.Name, short)
+		}
+	}
+
+	return 1
+}